@@ -0,0 +1,131 @@
+/**
+ * Copyright 2017 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+// ErrUnauthenticated means the request didn't present a usable identity at
+// all (missing or malformed credentials). Authenticator implementations
+// should return it to have ServeHTTP answer with 401.
+var ErrUnauthenticated = errors.New("request is not authenticated")
+
+// ErrForbidden means the request presented a usable identity, but that
+// identity isn't allowed to do what it's asking. Authenticator
+// implementations should return it to have ServeHTTP answer with 403.
+var ErrForbidden = errors.New("request is not permitted")
+
+// Authenticator validates an incoming request before it reaches the rest of
+// ServeHTTP. Returning nil admits the request; ErrUnauthenticated and
+// ErrForbidden map to 401 and 403 respectively, and any other error is
+// treated the same as ErrUnauthenticated.
+type Authenticator interface {
+	// Authenticate checks request's credentials.
+	Authenticate(request *http.Request) error
+
+	// Scheme is the value ServeHTTP sets on the WWW-Authenticate header
+	// when Authenticate returns ErrUnauthenticated, e.g. `Basic realm="caduceus"`.
+	Scheme() string
+}
+
+// BasicAuthenticator validates HTTP Basic credentials against a fixed set
+// of username/password pairs.
+type BasicAuthenticator struct {
+	realm       string
+	credentials map[string]string
+}
+
+// NewBasicAuthenticator constructs a BasicAuthenticator that accepts any of
+// the given username/password pairs. realm is reported back to clients via
+// WWW-Authenticate.
+func NewBasicAuthenticator(realm string, credentials map[string]string) *BasicAuthenticator {
+	return &BasicAuthenticator{realm: realm, credentials: credentials}
+}
+
+func (b *BasicAuthenticator) Authenticate(request *http.Request) error {
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	expected, known := b.credentials[username]
+	if !known || subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+		return ErrUnauthenticated
+	}
+
+	return nil
+}
+
+func (b *BasicAuthenticator) Scheme() string {
+	return `Basic realm="` + b.realm + `"`
+}
+
+// JWTAuthenticator validates a bearer JWT and, when requiredScope is
+// non-empty, requires it to be present in the token's "scope" claim.
+type JWTAuthenticator struct {
+	keyFunc       jwt.Keyfunc
+	requiredScope string
+}
+
+// NewJWTAuthenticator constructs a JWTAuthenticator. keyFunc resolves the
+// key used to verify a token's signature, as in jwt-go. requiredScope, if
+// non-empty, must appear in the token's space-delimited "scope" claim or
+// Authenticate returns ErrForbidden.
+func NewJWTAuthenticator(keyFunc jwt.Keyfunc, requiredScope string) *JWTAuthenticator {
+	return &JWTAuthenticator{keyFunc: keyFunc, requiredScope: requiredScope}
+}
+
+func (j *JWTAuthenticator) Authenticate(request *http.Request) error {
+	header := request.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ErrUnauthenticated
+	}
+
+	raw := strings.TrimPrefix(header, "Bearer ")
+	token, err := jwt.Parse(raw, j.keyFunc)
+	if err != nil || !token.Valid {
+		return ErrUnauthenticated
+	}
+
+	if j.requiredScope == "" {
+		return nil
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ErrForbidden
+	}
+
+	scopes, _ := claims["scope"].(string)
+	for _, scope := range strings.Fields(scopes) {
+		if scope == j.requiredScope {
+			return nil
+		}
+	}
+
+	return ErrForbidden
+}
+
+func (j *JWTAuthenticator) Scheme() string {
+	return "Bearer"
+}
@@ -0,0 +1,125 @@
+/**
+ * Copyright 2017 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+func TestBasicAuthenticator(t *testing.T) {
+	auth := NewBasicAuthenticator("caduceus", map[string]string{"alice": "secret"})
+
+	tests := []struct {
+		name     string
+		setup    func(request *http.Request)
+		expected error
+	}{
+		{"missing credentials", func(request *http.Request) {}, ErrUnauthenticated},
+		{"unknown user", func(request *http.Request) { request.SetBasicAuth("mallory", "whatever") }, ErrUnauthenticated},
+		{"wrong password", func(request *http.Request) { request.SetBasicAuth("alice", "wrong") }, ErrUnauthenticated},
+		{"valid credentials", func(request *http.Request) { request.SetBasicAuth("alice", "secret") }, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", nil)
+			test.setup(request)
+
+			if err := auth.Authenticate(request); err != test.expected {
+				t.Errorf("Authenticate() = %v, want %v", err, test.expected)
+			}
+		})
+	}
+
+	if scheme := auth.Scheme(); scheme != `Basic realm="caduceus"` {
+		t.Errorf("Scheme() = %q, want %q", scheme, `Basic realm="caduceus"`)
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	key := []byte("test-signing-key")
+	keyFunc := func(token *jwt.Token) (interface{}, error) { return key, nil }
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign test token: %v", err)
+		}
+		return signed
+	}
+
+	t.Run("missing authorization header", func(t *testing.T) {
+		auth := NewJWTAuthenticator(keyFunc, "")
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+
+		if err := auth.Authenticate(request); err != ErrUnauthenticated {
+			t.Errorf("Authenticate() = %v, want ErrUnauthenticated", err)
+		}
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+sign(jwt.MapClaims{}))
+
+		// Sign with the real key, but verify against a different one.
+		wrongKeyFunc := func(token *jwt.Token) (interface{}, error) { return []byte("wrong-key"), nil }
+		auth := NewJWTAuthenticator(wrongKeyFunc, "")
+
+		if err := auth.Authenticate(request); err != ErrUnauthenticated {
+			t.Errorf("Authenticate() = %v, want ErrUnauthenticated", err)
+		}
+	})
+
+	t.Run("valid token, no required scope", func(t *testing.T) {
+		auth := NewJWTAuthenticator(keyFunc, "")
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+sign(jwt.MapClaims{}))
+
+		if err := auth.Authenticate(request); err != nil {
+			t.Errorf("Authenticate() = %v, want nil", err)
+		}
+	})
+
+	t.Run("valid token missing required scope", func(t *testing.T) {
+		auth := NewJWTAuthenticator(keyFunc, "wrp:post")
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+sign(jwt.MapClaims{"scope": "wrp:get"}))
+
+		if err := auth.Authenticate(request); err != ErrForbidden {
+			t.Errorf("Authenticate() = %v, want ErrForbidden", err)
+		}
+	})
+
+	t.Run("valid token with required scope", func(t *testing.T) {
+		auth := NewJWTAuthenticator(keyFunc, "wrp:post")
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+sign(jwt.MapClaims{"scope": "wrp:get wrp:post"}))
+
+		if err := auth.Authenticate(request); err != nil {
+			t.Errorf("Authenticate() = %v, want nil", err)
+		}
+	})
+
+	if scheme := (&JWTAuthenticator{}).Scheme(); scheme != "Bearer" {
+		t.Errorf("Scheme() = %q, want %q", scheme, "Bearer")
+	}
+}
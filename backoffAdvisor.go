@@ -0,0 +1,123 @@
+/**
+ * Copyright 2017 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// defaultBackoffBase, defaultBackoffCap and defaultBackoffMultiplier are
+// used by NewBackoffAdvisor when the caller passes zero values.
+const (
+	defaultBackoffBase       = 1 * time.Second
+	defaultBackoffCap        = 30 * time.Second
+	defaultBackoffMultiplier = 2.0
+)
+
+// backoffRateAlpha is the weight given to the most recent Observe call when
+// updating the rejection-rate EWMA: higher means the advisor reacts to
+// bursts faster but forgets them faster too.
+const backoffRateAlpha = 0.2
+
+// backoffRateExponent controls how many multiplier "doublings" separate a
+// near-zero rejection rate from a rejection rate of 1.0.
+const backoffRateExponent = 6.0
+
+// BackoffAdvisor tracks recent load-shedding rejections and computes a
+// jittered, exponentially growing delay that callers can hand back to
+// clients (e.g. via a Retry-After header) so well-behaved senders ease off
+// on their own instead of retrying immediately.
+//
+// Rather than a simple rejected/accepted streak, which a single accepted
+// request in the middle of sustained overload would reset to zero,
+// BackoffAdvisor maintains an exponentially weighted moving average of the
+// rejection rate: isolated successes only nudge the advised delay down,
+// they don't zero it out.
+type BackoffAdvisor struct {
+	mu         sync.Mutex
+	base       time.Duration
+	cap        time.Duration
+	multiplier float64
+	rate       float64 // EWMA of recent rejections, in [0, 1]
+	rand       *rand.Rand
+}
+
+// NewBackoffAdvisor constructs a BackoffAdvisor. base is the delay advised
+// once the rejection rate starts climbing, cap bounds how large the delay
+// can grow, and multiplier controls how quickly it grows as the rejection
+// rate approaches 1. Zero values fall back to sane defaults.
+func NewBackoffAdvisor(base, cap time.Duration, multiplier float64) *BackoffAdvisor {
+	if base <= 0 {
+		base = defaultBackoffBase
+	}
+	if cap <= 0 {
+		cap = defaultBackoffCap
+	}
+	if multiplier <= 1 {
+		multiplier = defaultBackoffMultiplier
+	}
+
+	return &BackoffAdvisor{
+		base:       base,
+		cap:        cap,
+		multiplier: multiplier,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Observe records the outcome of an admission decision, folding it into the
+// recent rejection-rate estimate.
+func (b *BackoffAdvisor) Observe(rejected bool) {
+	observed := 0.0
+	if rejected {
+		observed = 1.0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rate = b.rate*(1-backoffRateAlpha) + observed*backoffRateAlpha
+}
+
+// NextDelay returns a jittered duration in [0, currentDelay] reflecting the
+// recent rejection rate, where currentDelay grows exponentially with that
+// rate from base (rate near 0) to base*multiplier^backoffRateExponent
+// (rate 1), and never exceeds cap. It's safe to call
+// NextDelay even when no rejection has been observed yet, in which case it
+// returns 0.
+func (b *BackoffAdvisor) NextDelay() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate <= 0 {
+		return 0
+	}
+
+	scaled := float64(b.base) * math.Pow(b.multiplier, b.rate*backoffRateExponent)
+	delay := time.Duration(scaled)
+	if delay > b.cap {
+		delay = b.cap
+	}
+
+	// Computed while still holding b.mu: rand.Rand is not safe for
+	// concurrent use, and concurrent 503s are exactly the load this advisor
+	// is meant to handle.
+	return time.Duration(b.rand.Int63n(int64(delay) + 1))
+}
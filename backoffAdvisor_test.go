@@ -0,0 +1,84 @@
+/**
+ * Copyright 2017 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoffAdvisorNoRejectionsAdvisesNoDelay(t *testing.T) {
+	advisor := NewBackoffAdvisor(time.Second, 30*time.Second, 2.0)
+
+	if delay := advisor.NextDelay(); delay != 0 {
+		t.Fatalf("NextDelay() = %v, want 0", delay)
+	}
+}
+
+func TestBackoffAdvisorGrowsWithSustainedRejections(t *testing.T) {
+	advisor := NewBackoffAdvisor(time.Second, 30*time.Second, 2.0)
+
+	for i := 0; i < 50; i++ {
+		advisor.Observe(true)
+	}
+
+	if delay := advisor.NextDelay(); delay <= 0 {
+		t.Fatalf("NextDelay() after sustained rejections = %v, want > 0", delay)
+	}
+}
+
+// TestBackoffAdvisorSingleSuccessDoesNotZeroDelay is the regression test for
+// a single accepted request wiping out an otherwise elevated delay: the
+// advisor tracks a recent rejection *rate*, not a streak a lone success
+// resets.
+func TestBackoffAdvisorSingleSuccessDoesNotZeroDelay(t *testing.T) {
+	advisor := NewBackoffAdvisor(time.Second, 30*time.Second, 2.0)
+
+	for i := 0; i < 50; i++ {
+		advisor.Observe(true)
+	}
+
+	before := advisor.NextDelay()
+	if before <= 0 {
+		t.Fatalf("NextDelay() after sustained rejections = %v, want > 0", before)
+	}
+
+	advisor.Observe(false)
+
+	if advisor.rate <= 0 {
+		t.Fatalf("rate after a single success = %v, want > 0 (one success shouldn't zero a sustained rejection rate)", advisor.rate)
+	}
+}
+
+func TestBackoffAdvisorObserveAndNextDelayUnderConcurrency(t *testing.T) {
+	advisor := NewBackoffAdvisor(time.Second, 30*time.Second, 2.0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			advisor.Observe(i%2 == 0)
+		}(i)
+		go func() {
+			defer wg.Done()
+			advisor.NextDelay()
+		}()
+	}
+	wg.Wait()
+}
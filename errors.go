@@ -0,0 +1,107 @@
+/**
+ * Copyright 2017 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// transactionUUIDHeader is the header clients may set to correlate a
+// request across logs; when present, it's echoed back in error envelopes.
+const transactionUUIDHeader = "X-Webpa-Transaction-Id"
+
+// AppError is the single shape every error response ServeHTTP writes is
+// built from: a stable machine-readable code, the HTTP status it maps to,
+// and a human-readable message.
+type AppError struct {
+	Code    string
+	Status  int
+	Message string
+}
+
+// The stable error codes ServeHTTP can respond with.
+var (
+	errBodyReadFailed       = AppError{Code: "WRP_BODY_READ_FAILED", Status: http.StatusBadRequest, Message: "Unable to retrieve the request body."}
+	errBodyTooLarge         = AppError{Code: "WRP_BODY_TOO_LARGE", Status: http.StatusRequestEntityTooLarge, Message: "Payload exceeds maximum size."}
+	errEmptyBody            = AppError{Code: "WRP_EMPTY_BODY", Status: http.StatusBadRequest, Message: "Empty payload."}
+	errDecodeFailed         = AppError{Code: "WRP_DECODE_FAILED", Status: http.StatusBadRequest, Message: "Invalid payload format."}
+	errQueueFull            = AppError{Code: "QUEUE_FULL", Status: http.StatusServiceUnavailable, Message: "Admission queue full."}
+	errEvicted              = AppError{Code: "ADMISSION_EVICTED", Status: http.StatusServiceUnavailable, Message: "Preempted by a higher-priority request."}
+	errUnauthenticated      = AppError{Code: "UNAUTHENTICATED", Status: http.StatusUnauthorized, Message: "Unauthorized."}
+	errForbidden            = AppError{Code: "FORBIDDEN", Status: http.StatusForbidden, Message: "Forbidden."}
+	errUnsupportedMediaType = AppError{Code: "UNSUPPORTED_MEDIA_TYPE", Status: http.StatusUnsupportedMediaType, Message: "Unsupported media type."}
+)
+
+// appErrorBody is the JSON envelope written for an AppError. TransactionUUID
+// is only populated from the inbound request's transactionUUIDHeader; it is
+// never taken from a decoded WRP message, and none is fabricated.
+type appErrorBody struct {
+	Code            string `json:"code"`
+	Message         string `json:"message"`
+	TransactionUUID string `json:"transactionUUID,omitempty"`
+}
+
+// acceptsPlainText reports whether request's Accept header lists text/plain
+// as one of the media types it will take, the way real clients negotiate
+// content ("text/plain, */*" or "text/plain; q=0.9"), rather than requiring
+// an exact "Accept: text/plain" match.
+func acceptsPlainText(request *http.Request) bool {
+	accept := request.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(candidate))
+		if err == nil && mediaType == "text/plain" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeError is the single place ServeHTTP writes an error response from.
+// It emits the JSON error envelope by default, falling back to the
+// previous plain-text body when the client asks for Accept: text/plain.
+func writeError(response http.ResponseWriter, request *http.Request, appErr AppError) {
+	if acceptsPlainText(request) {
+		response.WriteHeader(appErr.Status)
+		response.Write([]byte(appErr.Message + "\n"))
+		return
+	}
+
+	body, err := json.Marshal(appErrorBody{
+		Code:            appErr.Code,
+		Message:         appErr.Message,
+		TransactionUUID: request.Header.Get(transactionUUIDHeader),
+	})
+	if err != nil {
+		// Marshaling a fixed struct of strings should never fail; fall back
+		// to the plain-text body rather than swallowing the error entirely.
+		response.WriteHeader(appErr.Status)
+		response.Write([]byte(appErr.Message + "\n"))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(appErr.Status)
+	response.Write(body)
+}
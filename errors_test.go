@@ -0,0 +1,121 @@
+/**
+ * Copyright 2017 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteErrorJSONEnvelope(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	recorder := httptest.NewRecorder()
+
+	writeError(recorder, request, errDecodeFailed)
+
+	if recorder.Code != errDecodeFailed.Status {
+		t.Fatalf("status = %d, want %d", recorder.Code, errDecodeFailed.Status)
+	}
+
+	var body appErrorBody
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to unmarshal error envelope: %v", err)
+	}
+
+	if body.Code != errDecodeFailed.Code || body.Message != errDecodeFailed.Message {
+		t.Errorf("body = %+v, want code %q and message %q", body, errDecodeFailed.Code, errDecodeFailed.Message)
+	}
+
+	if body.TransactionUUID != "" {
+		t.Errorf("TransactionUUID = %q, want empty when the request carried none", body.TransactionUUID)
+	}
+}
+
+func TestWriteErrorEchoesInboundTransactionUUID(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	request.Header.Set(transactionUUIDHeader, "abc-123")
+	recorder := httptest.NewRecorder()
+
+	writeError(recorder, request, errEmptyBody)
+
+	var body appErrorBody
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to unmarshal error envelope: %v", err)
+	}
+
+	if body.TransactionUUID != "abc-123" {
+		t.Errorf("TransactionUUID = %q, want %q", body.TransactionUUID, "abc-123")
+	}
+}
+
+func TestWriteErrorPlainTextFallback(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	request.Header.Set("Accept", "text/plain")
+	recorder := httptest.NewRecorder()
+
+	writeError(recorder, request, errEmptyBody)
+
+	if recorder.Code != errEmptyBody.Status {
+		t.Fatalf("status = %d, want %d", recorder.Code, errEmptyBody.Status)
+	}
+
+	if got, want := recorder.Body.String(), errEmptyBody.Message+"\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType == "application/json" {
+		t.Errorf("Content-Type = %q, want the plain-text fallback not to set application/json", contentType)
+	}
+}
+
+func TestWriteErrorPlainTextFallbackNegotiated(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+	}{
+		{"multiple values", "text/plain, */*"},
+		{"quality parameter", "text/plain; q=0.9"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", nil)
+			request.Header.Set("Accept", test.accept)
+			recorder := httptest.NewRecorder()
+
+			writeError(recorder, request, errEmptyBody)
+
+			if got, want := recorder.Body.String(), errEmptyBody.Message+"\n"; got != want {
+				t.Errorf("body = %q, want %q", got, want)
+			}
+
+			if contentType := recorder.Header().Get("Content-Type"); contentType == "application/json" {
+				t.Errorf("Content-Type = %q, want the plain-text fallback not to set application/json", contentType)
+			}
+		})
+	}
+}
+
+// TestQueueFullMessageIsDistinctFromAcceptedMessage guards against the
+// 503 queue-full body reusing the same text as the 202 success body.
+func TestQueueFullMessageIsDistinctFromAcceptedMessage(t *testing.T) {
+	if errQueueFull.Message+"\n" == "Request placed on to queue.\n" {
+		t.Errorf("errQueueFull.Message = %q, must not match the 202 success body", errQueueFull.Message)
+	}
+}
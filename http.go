@@ -18,8 +18,10 @@ package main
 
 import (
 	"io/ioutil"
+	"math"
+	"mime"
 	"net/http"
-	"sync/atomic"
+	"strconv"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -29,6 +31,33 @@ import (
 	"github.com/xmidt-org/wrp-go/v2"
 )
 
+// contentTypeWrp maps the bare media type of the incoming request's
+// Content-Type header to the wrp format used to decode the payload.
+// Anything not listed here is rejected with a 415 before we ever touch the
+// body.
+var contentTypeWrp = map[string]wrp.Format{
+	"":                    wrp.Msgpack,
+	"application/msgpack": wrp.Msgpack,
+	"application/json":    wrp.JSON,
+}
+
+// mediaTypeOf strips any parameters (e.g. "; charset=utf-8") and whitespace
+// from a Content-Type header value, returning just the bare media type.
+// Headers that don't parse as a media type are returned unchanged so the
+// caller's map lookup still fails closed with a 415.
+func mediaTypeOf(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+
+	return mediaType
+}
+
 // Below is the struct that will implement our ServeHTTP method
 type ServerHandler struct {
 	log.Logger
@@ -38,8 +67,13 @@ type ServerHandler struct {
 	invalidCount             metrics.Counter
 	incomingQueueDepthMetric metrics.Gauge
 	modifiedWRPCount         metrics.Counter
-	incomingQueueDepth       int64
-	maxOutstanding           int64
+	acceptedFormatCount      metrics.Counter
+	admission                *PriorityAdmission
+	backoff                  *BackoffAdvisor
+	authenticator            Authenticator
+	unauthorizedRequests     metrics.Counter
+	forbiddenRequests        metrics.Counter
+	maxPayloadSize           int64
 }
 
 func (sh *ServerHandler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
@@ -51,53 +85,111 @@ func (sh *ServerHandler) ServeHTTP(response http.ResponseWriter, request *http.R
 
 	infoLog.Log(messageKey, "Receiving incoming request...")
 
-	outstanding := atomic.AddInt64(&sh.incomingQueueDepth, 1)
-	defer atomic.AddInt64(&sh.incomingQueueDepth, -1)
+	if sh.authenticator != nil {
+		if err := sh.authenticator.Authenticate(request); err != nil {
+			if err == ErrForbidden {
+				sh.forbiddenRequests.Add(1.0)
+				debugLog.Log(messageKey, "Forbidden.\n", errorKey, err.Error())
+				writeError(response, request, errForbidden)
+				return
+			}
+
+			sh.unauthorizedRequests.Add(1.0)
+			response.Header().Set("WWW-Authenticate", sh.authenticator.Scheme())
+			debugLog.Log(messageKey, "Unauthorized.\n", errorKey, err.Error())
+			writeError(response, request, errUnauthenticated)
+			return
+		}
+	}
 
-	if 0 < sh.maxOutstanding && sh.maxOutstanding < outstanding {
+	prio := priorityFromRequest(request)
+	release, evicted, err := sh.admission.Acquire(request.Context(), prio)
+	if err != nil {
 		// return a 503
-		response.WriteHeader(http.StatusServiceUnavailable)
-		response.Write([]byte("Request placed on to queue.\n"))
-		debugLog.Log(messageKey, "Request placed on to queue.\n")
+		sh.backoff.Observe(true)
+		response.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(sh.backoff.NextDelay().Seconds()))))
+		debugLog.Log(messageKey, "Request placed on to queue.\n", "priority", prio.priorityLabel())
+		writeError(response, request, errQueueFull)
+		return
+	}
+	defer release()
+	sh.backoff.Observe(false)
+
+	if appErr := sh.processPayload(response, request, debugLog, errorLog, messageKey, errorKey, evicted); appErr != nil {
+		writeError(response, request, *appErr)
 		return
 	}
 
-	sh.incomingQueueDepthMetric.Add(1.0)
-	defer sh.incomingQueueDepthMetric.Add(-1.0)
+	// return a 202
+	response.WriteHeader(http.StatusAccepted)
+	response.Write([]byte("Request placed on to queue.\n"))
+	debugLog.Log(messageKey, "Request placed on to queue.")
+}
+
+// processPayload reads, validates and decodes the WRP payload and hands it
+// off to the caduceusHandler. It returns nil on success, or the AppError
+// ServeHTTP should respond with.
+//
+// This runs entirely on ServeHTTP's own goroutine — request.Body and
+// response must never be touched once ServeHTTP has returned, so nothing
+// here may be backgrounded. evicted is checked immediately before
+// HandleRequest so a request preempted while its body was being read or
+// decoded is shed instead of delivered.
+func (sh *ServerHandler) processPayload(response http.ResponseWriter, request *http.Request, debugLog, errorLog log.Logger, messageKey, errorKey interface{}, evicted <-chan struct{}) *AppError {
+	// Decide on the content type from headers alone, before reading (and
+	// size-capping) the body: an unsupported type should fail closed
+	// without forcing the client to upload anything.
+	mediaType := mediaTypeOf(request.Header.Get("Content-Type"))
+	format, ok := contentTypeWrp[mediaType]
+	if !ok {
+		sh.invalidCount.Add(1.0)
+		debugLog.Log(messageKey, "Unsupported media type.\n", "contentType", request.Header.Get("Content-Type"))
+		return &errUnsupportedMediaType
+	}
+
+	body := request.Body
+	if sh.maxPayloadSize > 0 {
+		body = http.MaxBytesReader(response, body, sh.maxPayloadSize)
+	}
 
-	payload, err := ioutil.ReadAll(request.Body)
+	payload, err := ioutil.ReadAll(body)
 	if err != nil {
+		if sh.maxPayloadSize > 0 && err.Error() == "http: request body too large" {
+			errorLog.Log(messageKey, "Payload exceeds maximum size.", errorKey, err.Error())
+			return &errBodyTooLarge
+		}
+
 		sh.errorRequests.Add(1.0)
 		errorLog.Log(messageKey, "Unable to retrieve the request body.", errorKey, err.Error)
-		response.WriteHeader(http.StatusBadRequest)
-		return
+		return &errBodyReadFailed
 	}
 
 	if len(payload) == 0 {
 		sh.emptyRequests.Add(1.0)
 		errorLog.Log(messageKey, "Empty payload.", errorKey)
-		response.WriteHeader(http.StatusBadRequest)
-		response.Write([]byte("Empty payload.\n"))
-		return
+		return &errEmptyBody
 	}
 
-	decoder := wrp.NewDecoderBytes(payload, wrp.Msgpack)
+	sh.acceptedFormatCount.With("format", format.String()).Add(1.0)
+
+	decoder := wrp.NewDecoderBytes(payload, format)
 	msg := new(wrp.Message)
 	if err := decoder.Decode(msg); err != nil {
 		// return a 400
 		sh.invalidCount.Add(1.0)
-		response.WriteHeader(http.StatusBadRequest)
-		response.Write([]byte("Invalid payload format.\n"))
 		debugLog.Log(messageKey, "Invalid payload format.\n")
-		return
+		return &errDecodeFailed
 	}
 
-	sh.caduceusHandler.HandleRequest(0, sh.fixWrp(msg))
+	select {
+	case <-evicted:
+		debugLog.Log(messageKey, "Preempted by a higher-priority request.\n")
+		return &errEvicted
+	default:
+	}
 
-	// return a 202
-	response.WriteHeader(http.StatusAccepted)
-	response.Write([]byte("Request placed on to queue.\n"))
-	debugLog.Log(messageKey, "Request placed on to queue.")
+	sh.caduceusHandler.HandleRequest(0, sh.fixWrp(msg))
+	return nil
 }
 
 func (sh *ServerHandler) fixWrp(msg *wrp.Message) *wrp.Message {
@@ -0,0 +1,315 @@
+/**
+ * Copyright 2017 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/xmidt-org/wrp-go/v2"
+)
+
+func TestMediaTypeOf(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    string
+	}{
+		{"", ""},
+		{"application/json", "application/json"},
+		{"application/json; charset=utf-8", "application/json"},
+		{"application/msgpack", "application/msgpack"},
+		{"  application/json ; charset=utf-8", "application/json"},
+		{"not a media type;;;", "not a media type;;;"},
+	}
+
+	for _, test := range tests {
+		if actual := mediaTypeOf(test.contentType); actual != test.expected {
+			t.Errorf("mediaTypeOf(%q) = %q, want %q", test.contentType, actual, test.expected)
+		}
+	}
+}
+
+// stubRequestHandler records every HandleRequest call so tests can assert
+// whether (and how often) a message was actually delivered.
+type stubRequestHandler struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *stubRequestHandler) HandleRequest(workerID int, msg *wrp.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+}
+
+func (s *stubRequestHandler) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// stubAuthenticator returns a fixed error regardless of the request.
+type stubAuthenticator struct {
+	err error
+}
+
+func (s *stubAuthenticator) Authenticate(request *http.Request) error { return s.err }
+func (s *stubAuthenticator) Scheme() string                          { return `Stub realm="test"` }
+
+// nopCounter and nopGauge satisfy the go-kit metrics interfaces without
+// recording anything, for tests that only care about ServeHTTP's behavior.
+type nopCounter struct{}
+
+func (nopCounter) With(labelValues ...string) metrics.Counter { return nopCounter{} }
+func (nopCounter) Add(delta float64)                          {}
+
+type nopGauge struct{}
+
+func (nopGauge) With(labelValues ...string) metrics.Gauge { return nopGauge{} }
+func (nopGauge) Set(value float64)                        {}
+func (nopGauge) Add(delta float64)                         {}
+
+// blockingBody is an io.ReadCloser that blocks on Read until unblock is
+// closed, so tests can hold ServeHTTP inside processPayload's body read
+// while they manipulate admission state from another goroutine.
+type blockingBody struct {
+	unblock <-chan struct{}
+	payload []byte
+	read    bool
+}
+
+func (b *blockingBody) Read(p []byte) (int, error) {
+	<-b.unblock
+	if b.read {
+		return 0, io.EOF
+	}
+	b.read = true
+	return copy(p, b.payload), nil
+}
+
+func (b *blockingBody) Close() error { return nil }
+
+func newTestServerHandler(handler RequestHandler, authenticator Authenticator, admission *PriorityAdmission) *ServerHandler {
+	return &ServerHandler{
+		Logger:                   log.NewNopLogger(),
+		caduceusHandler:          handler,
+		errorRequests:            nopCounter{},
+		emptyRequests:            nopCounter{},
+		invalidCount:             nopCounter{},
+		incomingQueueDepthMetric: nopGauge{},
+		modifiedWRPCount:         nopCounter{},
+		acceptedFormatCount:      nopCounter{},
+		admission:                admission,
+		backoff:                  NewBackoffAdvisor(time.Millisecond, time.Second, 2.0),
+		authenticator:            authenticator,
+		unauthorizedRequests:     nopCounter{},
+		forbiddenRequests:        nopCounter{},
+	}
+}
+
+func validWRPPayload(t *testing.T) []byte {
+	t.Helper()
+
+	msg := &wrp.Message{Type: wrp.SimpleEventMessageType, Source: "test", Destination: "mac:112233445566/test"}
+
+	var payload []byte
+	encoder := wrp.NewEncoderBytes(&payload, wrp.JSON)
+	if err := encoder.Encode(msg); err != nil {
+		t.Fatalf("failed to encode test WRP message: %v", err)
+	}
+
+	return payload
+}
+
+func TestServeHTTPUnauthenticated(t *testing.T) {
+	handler := &stubRequestHandler{}
+	sh := newTestServerHandler(handler, &stubAuthenticator{err: ErrUnauthenticated}, NewPriorityAdmission(0, nil))
+
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	recorder := httptest.NewRecorder()
+
+	sh.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+
+	if got, want := recorder.Header().Get("WWW-Authenticate"), sh.authenticator.Scheme(); got != want {
+		t.Errorf("WWW-Authenticate = %q, want %q", got, want)
+	}
+
+	if handler.callCount() != 0 {
+		t.Errorf("HandleRequest was called %d times, want 0", handler.callCount())
+	}
+}
+
+func TestServeHTTPForbidden(t *testing.T) {
+	handler := &stubRequestHandler{}
+	sh := newTestServerHandler(handler, &stubAuthenticator{err: ErrForbidden}, NewPriorityAdmission(0, nil))
+
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	recorder := httptest.NewRecorder()
+
+	sh.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+
+	if got := recorder.Header().Get("WWW-Authenticate"); got != "" {
+		t.Errorf("WWW-Authenticate = %q, want unset for a forbidden (not unauthenticated) request", got)
+	}
+
+	if handler.callCount() != 0 {
+		t.Errorf("HandleRequest was called %d times, want 0", handler.callCount())
+	}
+}
+
+// TestServeHTTPUnsupportedMediaTypeSkipsBodyRead guards the chunk0-1 fix:
+// an unsupported Content-Type must short-circuit before the body is ever
+// read.
+func TestServeHTTPUnsupportedMediaTypeSkipsBodyRead(t *testing.T) {
+	handler := &stubRequestHandler{}
+	sh := newTestServerHandler(handler, nil, NewPriorityAdmission(0, nil))
+
+	body := &blockingBody{unblock: make(chan struct{})} // never unblocked
+	request := httptest.NewRequest(http.MethodPost, "/", body)
+	request.Header.Set("Content-Type", "application/xml")
+	recorder := httptest.NewRecorder()
+
+	sh.ServeHTTP(recorder, request)
+
+	if recorder.Code != errUnsupportedMediaType.Status {
+		t.Fatalf("status = %d, want %d", recorder.Code, errUnsupportedMediaType.Status)
+	}
+
+	if handler.callCount() != 0 {
+		t.Errorf("HandleRequest was called %d times, want 0", handler.callCount())
+	}
+}
+
+func TestServeHTTPQueueFullSetsRetryAfter(t *testing.T) {
+	admission := NewPriorityAdmission(1, nil)
+	release, _, err := admission.Acquire(context.Background(), PriorityHigh)
+	if err != nil {
+		t.Fatalf("failed to pre-occupy the admission slot: %v", err)
+	}
+	defer release()
+
+	handler := &stubRequestHandler{}
+	sh := newTestServerHandler(handler, nil, admission)
+
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	recorder := httptest.NewRecorder()
+
+	sh.ServeHTTP(recorder, request)
+
+	if recorder.Code != errQueueFull.Status {
+		t.Fatalf("status = %d, want %d", recorder.Code, errQueueFull.Status)
+	}
+
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After header was not set on a queue-full response")
+	}
+
+	if handler.callCount() != 0 {
+		t.Errorf("HandleRequest was called %d times, want 0", handler.callCount())
+	}
+}
+
+func TestServeHTTPAccepted(t *testing.T) {
+	handler := &stubRequestHandler{}
+	sh := newTestServerHandler(handler, nil, NewPriorityAdmission(0, nil))
+
+	request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(validWRPPayload(t)))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+
+	sh.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusAccepted)
+	}
+
+	if handler.callCount() != 1 {
+		t.Errorf("HandleRequest was called %d times, want 1", handler.callCount())
+	}
+}
+
+// TestServeHTTPEvictedAbortsBeforeHandleRequest guards the chunk0-2 fix: a
+// request preempted while its body is still being read must be shed with a
+// 503 instead of delivered.
+func TestServeHTTPEvictedAbortsBeforeHandleRequest(t *testing.T) {
+	admission := NewPriorityAdmission(1, nil)
+	handler := &stubRequestHandler{}
+	sh := newTestServerHandler(handler, nil, admission)
+
+	unblock := make(chan struct{})
+	body := &blockingBody{unblock: unblock, payload: validWRPPayload(t)}
+	request := httptest.NewRequest(http.MethodPost, "/", body)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(priorityHeaderName, "0")
+	recorder := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sh.ServeHTTP(recorder, request)
+	}()
+
+	// Wait for ServeHTTP's own Acquire to occupy the single slot before we
+	// preempt it out from under the in-flight request.
+	deadline := time.After(time.Second)
+	for {
+		admission.mu.Lock()
+		occupied := len(admission.active) == 1
+		admission.mu.Unlock()
+		if occupied {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for the in-flight request to acquire its admission slot")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	release, _, err := admission.Acquire(context.Background(), PriorityHigh)
+	if err != nil {
+		t.Fatalf("failed to preempt the in-flight request's slot: %v", err)
+	}
+	defer release()
+
+	close(unblock)
+	<-done
+
+	if recorder.Code != errEvicted.Status {
+		t.Fatalf("status = %d, want %d", recorder.Code, errEvicted.Status)
+	}
+
+	if handler.callCount() != 0 {
+		t.Errorf("HandleRequest was called %d times, want 0 for an evicted request", handler.callCount())
+	}
+}
@@ -0,0 +1,214 @@
+/**
+ * Copyright 2017 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// Priority classifies an admission request so that PriorityAdmission can
+// decide who gets shed first when the queue is at capacity.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+// priorityHeaderName is the header clients set to steer admission; it is
+// the only input priorityFromRequest reads (WRP QualityOfService is not
+// consulted).
+const priorityHeaderName = "X-Caduceus-Priority"
+
+// priorityLabel is used as the metrics.Gauge label value for each Priority.
+func (p Priority) priorityLabel() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+// priorityFromRequest determines the Priority of an incoming request from
+// the X-Caduceus-Priority header, defaulting to PriorityNormal when the
+// header is absent or unparsable.
+func priorityFromRequest(request *http.Request) Priority {
+	raw := request.Header.Get(priorityHeaderName)
+	if raw == "" {
+		return PriorityNormal
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return PriorityNormal
+	}
+
+	switch {
+	case value >= 66:
+		return PriorityHigh
+	case value >= 33:
+		return PriorityNormal
+	default:
+		return PriorityLow
+	}
+}
+
+// ErrQueueFull is returned by Acquire when the admission queue is at
+// capacity and the requesting priority isn't high enough to preempt
+// anything already admitted.
+var ErrQueueFull = errors.New("admission queue is full")
+
+// admissionToken represents a single admitted (or evicted) slot.
+type admissionToken struct {
+	priority Priority
+	seq      uint64
+	index    int
+	evicted  chan struct{}
+}
+
+// tokenHeap is a container/heap.Interface ordering tokens so that the
+// lowest priority (and, within a priority, the oldest arrival) sorts first.
+// That makes the head of the heap exactly the token Acquire should evict
+// when a higher-priority request needs room.
+type tokenHeap []*admissionToken
+
+func (h tokenHeap) Len() int { return len(h) }
+
+func (h tokenHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h tokenHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *tokenHeap) Push(x interface{}) {
+	token := x.(*admissionToken)
+	token.index = len(*h)
+	*h = append(*h, token)
+}
+
+func (h *tokenHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	token := old[n-1]
+	old[n-1] = nil
+	token.index = -1
+	*h = old[:n-1]
+	return token
+}
+
+// PriorityAdmission is a bounded admission queue that sheds low-priority
+// requests first once it's at capacity, and lets a high-priority request
+// preempt a lower-priority one that's already holding a slot.
+//
+// Acquire is meant to be called from ServeHTTP before handing the request
+// off to the RequestHandler; the returned release func must be deferred so
+// the slot is freed once the request finishes.
+type PriorityAdmission struct {
+	mu             sync.Mutex
+	active         tokenHeap
+	seq            uint64
+	maxOutstanding int64
+	depthMetric    metrics.Gauge
+}
+
+// NewPriorityAdmission constructs a PriorityAdmission bounded to
+// maxOutstanding concurrent slots. maxOutstanding <= 0 means unbounded.
+// depthMetric, if non-nil, is updated with per-priority gauge labels as
+// slots are acquired and released.
+func NewPriorityAdmission(maxOutstanding int64, depthMetric metrics.Gauge) *PriorityAdmission {
+	return &PriorityAdmission{
+		maxOutstanding: maxOutstanding,
+		depthMetric:    depthMetric,
+	}
+}
+
+// Acquire reserves an admission slot for prio, evicting the lowest-priority
+// already-admitted slot if the queue is full and prio outranks it. The
+// returned release func must be called exactly once to free the slot. If no
+// slot can be obtained, Acquire returns ErrQueueFull.
+//
+// The returned channel is closed if a higher-priority Acquire call later
+// preempts this slot. Preemption only revokes the slot's accounting in
+// PriorityAdmission — it does not stop whatever work the caller is already
+// doing with it. Callers that want eviction to actually shed load must
+// select on the channel at their own checkpoints (or alongside the work
+// itself) and abort instead of completing normally when it fires.
+//
+// ctx is accepted so callers can thread request-scoped cancellation through
+// to future blocking variants of Acquire; the current implementation never
+// blocks and ignores ctx.Done().
+func (pa *PriorityAdmission) Acquire(ctx context.Context, prio Priority) (func(), <-chan struct{}, error) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	if pa.maxOutstanding > 0 && int64(len(pa.active)) >= pa.maxOutstanding {
+		lowest := pa.active[0]
+		if prio <= lowest.priority {
+			return nil, nil, ErrQueueFull
+		}
+
+		heap.Pop(&pa.active)
+		close(lowest.evicted)
+		pa.setDepth(lowest.priority, -1)
+	}
+
+	pa.seq++
+	token := &admissionToken{priority: prio, seq: pa.seq, evicted: make(chan struct{})}
+	heap.Push(&pa.active, token)
+	pa.setDepth(prio, 1)
+
+	return func() { pa.release(token) }, token.evicted, nil
+}
+
+func (pa *PriorityAdmission) release(token *admissionToken) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	if token.index < 0 {
+		// already evicted
+		return
+	}
+
+	heap.Remove(&pa.active, token.index)
+	pa.setDepth(token.priority, -1)
+}
+
+// setDepth must be called with pa.mu held.
+func (pa *PriorityAdmission) setDepth(prio Priority, delta float64) {
+	if pa.depthMetric == nil {
+		return
+	}
+	pa.depthMetric.With("priority", prio.priorityLabel()).Add(delta)
+}
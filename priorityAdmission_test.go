@@ -0,0 +1,102 @@
+/**
+ * Copyright 2017 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPriorityAdmissionAcquireRelease(t *testing.T) {
+	pa := NewPriorityAdmission(1, nil)
+
+	release, _, err := pa.Acquire(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatalf("Acquire() = %v, want nil", err)
+	}
+
+	if _, _, err := pa.Acquire(context.Background(), PriorityNormal); err != ErrQueueFull {
+		t.Fatalf("Acquire() at capacity = %v, want ErrQueueFull", err)
+	}
+
+	release()
+
+	if _, _, err := pa.Acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatalf("Acquire() after release = %v, want nil", err)
+	}
+}
+
+func TestPriorityAdmissionRejectsEqualPriorityAtCapacity(t *testing.T) {
+	pa := NewPriorityAdmission(1, nil)
+
+	release, _, err := pa.Acquire(context.Background(), PriorityHigh)
+	if err != nil {
+		t.Fatalf("Acquire(high) = %v, want nil", err)
+	}
+	defer release()
+
+	if _, _, err := pa.Acquire(context.Background(), PriorityHigh); err != ErrQueueFull {
+		t.Fatalf("Acquire(high) at capacity = %v, want ErrQueueFull", err)
+	}
+}
+
+// TestPriorityAdmissionPreemptsAndSignalsEviction is the regression test for
+// the eviction no-op: closing the evicted channel is the only signal a
+// preempted caller gets, so it must actually fire.
+func TestPriorityAdmissionPreemptsAndSignalsEviction(t *testing.T) {
+	pa := NewPriorityAdmission(1, nil)
+
+	_, lowEvicted, err := pa.Acquire(context.Background(), PriorityLow)
+	if err != nil {
+		t.Fatalf("Acquire(low) = %v, want nil", err)
+	}
+
+	releaseHigh, _, err := pa.Acquire(context.Background(), PriorityHigh)
+	if err != nil {
+		t.Fatalf("Acquire(high) = %v, want nil", err)
+	}
+	defer releaseHigh()
+
+	select {
+	case <-lowEvicted:
+	case <-time.After(time.Second):
+		t.Fatal("expected the low-priority slot's evicted channel to be closed")
+	}
+}
+
+func TestPriorityAdmissionReleaseAfterEvictionIsHarmless(t *testing.T) {
+	pa := NewPriorityAdmission(1, nil)
+
+	releaseLow, lowEvicted, err := pa.Acquire(context.Background(), PriorityLow)
+	if err != nil {
+		t.Fatalf("Acquire(low) = %v, want nil", err)
+	}
+
+	releaseHigh, _, err := pa.Acquire(context.Background(), PriorityHigh)
+	if err != nil {
+		t.Fatalf("Acquire(high) = %v, want nil", err)
+	}
+	defer releaseHigh()
+
+	<-lowEvicted
+	releaseLow()
+
+	if _, _, err := pa.Acquire(context.Background(), PriorityLow); err != ErrQueueFull {
+		t.Fatalf("Acquire(low) = %v, want ErrQueueFull (releasing an evicted token must not free a slot)", err)
+	}
+}